@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const apiKeyContextKey contextKey = "apiKey"
+
+// apiKeySet is the set of bearer tokens this adapter accepts from inbound
+// callers. An empty set disables auth entirely, so deployments that don't
+// configure API_KEYS / API_KEYS_FILE keep forwarding every caller unchanged.
+type apiKeySet map[string]bool
+
+// loadAPIKeys reads accepted keys from the file at API_KEYS_FILE (one key
+// per line) and/or the comma-separated API_KEYS env var.
+func loadAPIKeys() (apiKeySet, error) {
+	keys := make(apiKeySet)
+
+	if path := os.Getenv("API_KEYS_FILE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("opening API_KEYS_FILE: %w", err)
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if key := strings.TrimSpace(scanner.Text()); key != "" {
+				keys[key] = true
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("reading API_KEYS_FILE: %w", err)
+		}
+	}
+
+	for _, key := range strings.Split(os.Getenv("API_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys[key] = true
+		}
+	}
+
+	return keys, nil
+}
+
+func (keys apiKeySet) enabled() bool {
+	return len(keys) > 0
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>"
+// header, returning "" if the header is absent or malformed.
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(header, prefix)
+}
+
+// contextWithAPIKey stores the validated API key on ctx.
+func contextWithAPIKey(ctx context.Context, apiKey string) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey, apiKey)
+}
+
+// apiKeyFromContext returns the bearer token withAuth validated for this
+// request, or "" when auth is disabled.
+func apiKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(apiKeyContextKey).(string)
+	return key
+}
+
+// withAuth validates the Authorization header against keys and enforces the
+// per-key requests/minute budget before handing off to next. When keys is
+// empty, auth is a no-op and every caller passes through, matching today's
+// behavior. The validated API key is stashed in the request context for
+// handlers that also need to check a tokens/minute budget.
+func withAuth(keys apiKeySet, limiter *keyRateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiKey := bearerToken(r)
+
+		if keys.enabled() {
+			if apiKey == "" || !keys[apiKey] {
+				writeAPIError(w, http.StatusUnauthorized, APIError{
+					Message: "Incorrect API key provided.",
+					Type:    "invalid_request_error",
+					Code:    strPtr("invalid_api_key"),
+				})
+				return
+			}
+		}
+
+		allowed, remaining := limiter.allowRequest(apiKey)
+		if remaining >= 0 {
+			w.Header().Set("X-RateLimit-Remaining-Requests", strconv.Itoa(remaining))
+		}
+		if !allowed {
+			writeAPIError(w, http.StatusTooManyRequests, APIError{
+				Message: "Rate limit reached for requests. Please slow down.",
+				Type:    "requests",
+				Code:    strPtr("rate_limit_exceeded"),
+			})
+			return
+		}
+
+		ctx := r.Context()
+		if apiKey != "" {
+			ctx = contextWithAPIKey(ctx, apiKey)
+		}
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// keyRateLimiter enforces independent requests/minute and tokens/minute
+// budgets per API key using a token-bucket limiter for each, surfaced to
+// clients via X-RateLimit-Remaining-* headers the way OpenAI does. Requests
+// made without an API key (auth disabled) share a single bucket keyed by "".
+type keyRateLimiter struct {
+	requestsPerMinute int
+	tokensPerMinute   int
+
+	mu             sync.Mutex
+	requestLimiter map[string]*rate.Limiter
+	tokenLimiter   map[string]*rate.Limiter
+}
+
+func newKeyRateLimiter(requestsPerMinute, tokensPerMinute int) *keyRateLimiter {
+	return &keyRateLimiter{
+		requestsPerMinute: requestsPerMinute,
+		tokensPerMinute:   tokensPerMinute,
+		requestLimiter:    make(map[string]*rate.Limiter),
+		tokenLimiter:      make(map[string]*rate.Limiter),
+	}
+}
+
+func perMinuteLimiter(ratePerMinute int) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(float64(ratePerMinute)/60.0), ratePerMinute)
+}
+
+func (l *keyRateLimiter) limiterFor(m map[string]*rate.Limiter, key string, ratePerMinute int) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lim, ok := m[key]
+	if !ok {
+		lim = perMinuteLimiter(ratePerMinute)
+		m[key] = lim
+	}
+	return lim
+}
+
+// allowRequest reports whether key may make another request right now, and
+// how many requests remain in its current burst budget. remaining is -1
+// when the requests/minute limit is disabled.
+func (l *keyRateLimiter) allowRequest(key string) (allowed bool, remaining int) {
+	if l.requestsPerMinute <= 0 {
+		return true, -1
+	}
+	lim := l.limiterFor(l.requestLimiter, key, l.requestsPerMinute)
+	return lim.Allow(), int(lim.Tokens())
+}
+
+// allowTokens reports whether key has budget for n more tokens this minute,
+// consuming them if so, and how many tokens remain. remaining is -1 when the
+// tokens/minute limit is disabled. Callers must reject n that exceeds the
+// tokens/minute budget via checkTokenBudget first: the limiter's burst
+// equals tokensPerMinute, so AllowN can never admit a larger n no matter how
+// idle the bucket is.
+func (l *keyRateLimiter) allowTokens(key string, n int) (allowed bool, remaining int) {
+	if l.tokensPerMinute <= 0 {
+		return true, -1
+	}
+	lim := l.limiterFor(l.tokenLimiter, key, l.tokensPerMinute)
+	return lim.AllowN(time.Now(), n), int(lim.Tokens())
+}
+
+// tokenBudgetError reports that a request's token count can never fit
+// within the configured tokens/minute budget, regardless of how idle the
+// bucket is.
+type tokenBudgetError struct {
+	totalTokens     int
+	tokensPerMinute int
+}
+
+func (e *tokenBudgetError) Error() string {
+	return fmt.Sprintf("this request contains %d tokens, which exceeds the configured tokens-per-minute limit of %d tokens and can never be served",
+		e.totalTokens, e.tokensPerMinute)
+}
+
+// checkTokenBudget rejects n outright when it exceeds the tokens/minute
+// limit, before it ever reaches allowTokens. Without this, a request larger
+// than the whole per-minute budget would either bypass the limit entirely
+// or be 429ed forever with no indication it could never succeed -- this
+// gives the caller a clear, non-retryable error instead.
+func (l *keyRateLimiter) checkTokenBudget(n int) error {
+	if l.tokensPerMinute > 0 && n > l.tokensPerMinute {
+		return &tokenBudgetError{totalTokens: n, tokensPerMinute: l.tokensPerMinute}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestCheckTokenBudgetRejectsOversizedRequest(t *testing.T) {
+	l := newKeyRateLimiter(0, 100)
+
+	err := l.checkTokenBudget(150)
+	if err == nil {
+		t.Fatal("expected an error for a request exceeding the tokens/minute budget")
+	}
+
+	allowed, _ := l.allowTokens("key", 100)
+	if !allowed {
+		t.Error("a request at the budget should still be allowed once checkTokenBudget has passed")
+	}
+}
+
+func TestCheckTokenBudgetAllowsWithinBudget(t *testing.T) {
+	l := newKeyRateLimiter(0, 100)
+
+	if err := l.checkTokenBudget(100); err != nil {
+		t.Errorf("expected no error for a request exactly at the budget, got %v", err)
+	}
+	if err := l.checkTokenBudget(1); err != nil {
+		t.Errorf("expected no error for a small request, got %v", err)
+	}
+}
+
+func TestCheckTokenBudgetDisabled(t *testing.T) {
+	l := newKeyRateLimiter(0, 0)
+
+	if err := l.checkTokenBudget(1_000_000); err != nil {
+		t.Errorf("expected no error when tokens/minute limiting is disabled, got %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// batchDispatchConfig bundles the per-request knobs needed to dispatch
+// batches against an OVH backend concurrently.
+type batchDispatchConfig struct {
+	backendURL  string
+	token       string
+	concurrency int
+	maxAttempts int
+}
+
+// splitIntoBatches groups texts into batches that respect both a maximum
+// item count (maxBatchSize) and, when positive, a maximum total token count
+// per batch (maxBatchTokens), splitting oversized batches automatically
+// rather than forwarding a batch the backend or model context can't handle.
+func splitIntoBatches(texts []string, tokenCounts []int, maxBatchSize int, maxBatchTokens int) [][]string {
+	var batches [][]string
+	var current []string
+	currentTokens := 0
+	for i, text := range texts {
+		tokens := tokenCounts[i]
+		startsNewBatch := len(current) >= maxBatchSize ||
+			(maxBatchTokens > 0 && len(current) > 0 && currentTokens+tokens > maxBatchTokens)
+		if startsNewBatch {
+			batches = append(batches, current)
+			current = nil
+			currentTokens = 0
+		}
+		current = append(current, text)
+		currentTokens += tokens
+	}
+	if len(current) > 0 {
+		batches = append(batches, current)
+	}
+	return batches
+}
+
+// httpStatusError carries the upstream status code and any Retry-After
+// value so the retry loop can decide whether and how long to wait.
+type httpStatusError struct {
+	statusCode int
+	retryAfter time.Duration
+	body       string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("OVH API returned %d: %s", e.statusCode, e.body)
+}
+
+func (e *httpStatusError) retryable() bool {
+	return e.statusCode == http.StatusTooManyRequests || e.statusCode >= http.StatusInternalServerError
+}
+
+// batchOffsets returns, for each batch, the index of its first item within
+// the flattened input, so per-batch results can be reported against their
+// original position.
+func batchOffsets(batches [][]string) []int {
+	offsets := make([]int, len(batches))
+	offset := 0
+	for i, batch := range batches {
+		offsets[i] = offset
+		offset += len(batch)
+	}
+	return offsets
+}
+
+// dispatchBatches sends each of batches to the OVH backend through a bounded
+// worker pool of size cfg.concurrency, retrying failed batches with
+// exponential backoff and jitter. Results are written into a pre-sized slice
+// indexed by batch, so output ordering is preserved regardless of completion
+// order. ctx is threaded into every outbound request so a client disconnect
+// aborts in-flight OVH calls.
+func dispatchBatches(ctx context.Context, batches [][]string, cfg batchDispatchConfig) ([][]float64, error) {
+	offsets := batchOffsets(batches)
+	results := make([][][]float64, len(batches))
+	errs := make([]error, len(batches))
+
+	sem := make(chan struct{}, cfg.concurrency)
+	var wg sync.WaitGroup
+	for i, batch := range batches {
+		i, batch := i, batch
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			slog.Info("dispatching batch", "request_id", requestIDFromContext(ctx), "batch_index", i, "batch_count", len(batches), "batch_size", len(batch))
+			embeddings, err := dispatchBatchWithRetry(ctx, i, batch, cfg)
+			results[i] = embeddings
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("batch starting at index %d: %w", offsets[i], err)
+		}
+	}
+
+	var allEmbeddings [][]float64
+	for _, batchEmbeddings := range results {
+		allEmbeddings = append(allEmbeddings, batchEmbeddings...)
+	}
+	return allEmbeddings, nil
+}
+
+// dispatchBatchesStreaming behaves like dispatchBatches but invokes onResult
+// as each batch finishes instead of waiting for all of them, so callers can
+// stream partial results to a client. onResult may be called concurrently
+// from multiple goroutines and must synchronize its own side effects.
+func dispatchBatchesStreaming(ctx context.Context, batches [][]string, cfg batchDispatchConfig, onResult func(batchStartIndex int, embeddings [][]float64, err error)) {
+	offsets := batchOffsets(batches)
+
+	sem := make(chan struct{}, cfg.concurrency)
+	var wg sync.WaitGroup
+	for i, batch := range batches {
+		i, batch := i, batch
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			slog.Info("dispatching batch", "request_id", requestIDFromContext(ctx), "batch_index", i, "batch_count", len(batches), "batch_size", len(batch))
+			embeddings, err := dispatchBatchWithRetry(ctx, i, batch, cfg)
+			onResult(offsets[i], embeddings, err)
+		}()
+	}
+	wg.Wait()
+}
+
+// dispatchBatchWithRetry sends a single batch, retrying on network errors
+// and 429/5xx responses with exponential backoff, honoring Retry-After when
+// the upstream supplies one.
+func dispatchBatchWithRetry(ctx context.Context, batchIndex int, batch []string, cfg batchDispatchConfig) ([][]float64, error) {
+	var lastErr error
+	for attempt := 1; attempt <= cfg.maxAttempts; attempt++ {
+		embeddings, err := dispatchBatchOnce(ctx, batch, cfg)
+		if err == nil {
+			return embeddings, nil
+		}
+		lastErr = err
+
+		var statusErr *httpStatusError
+		var retryable bool
+		switch {
+		case errors.As(err, &statusErr):
+			retryable = statusErr.retryable()
+		case errors.Is(err, errNetworkFailure):
+			retryable = ctx.Err() == nil
+		}
+		if !retryable || attempt == cfg.maxAttempts {
+			break
+		}
+
+		wait := backoffWithJitter(attempt)
+		if statusErr != nil && statusErr.retryAfter > 0 {
+			wait = statusErr.retryAfter
+		}
+		slog.Warn("batch attempt failed, retrying",
+			"request_id", requestIDFromContext(ctx),
+			"batch_index", batchIndex,
+			"attempt", attempt,
+			"max_attempts", cfg.maxAttempts,
+			"error", err,
+			"retry_in", wait,
+		)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	return nil, lastErr
+}
+
+// errNetworkFailure marks transport-level errors (timeouts, connection
+// resets) as distinct from non-retryable request-construction errors.
+var errNetworkFailure = errors.New("network failure calling OVH API")
+
+func dispatchBatchOnce(ctx context.Context, batch []string, cfg batchDispatchConfig) ([][]float64, error) {
+	batchJSON, err := json.Marshal(batch)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling batch: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", cfg.backendURL, bytes.NewBuffer(batchJSON))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", cfg.token))
+
+	start := time.Now()
+	client := &http.Client{}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNetworkFailure, err)
+	}
+	defer resp.Body.Close()
+	latency := time.Since(start)
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := ioutil.ReadAll(resp.Body)
+		slog.Warn("upstream batch request failed",
+			"request_id", requestIDFromContext(ctx),
+			"upstream_status", resp.StatusCode,
+			"latency_ms", latency.Milliseconds(),
+		)
+		return nil, &httpStatusError{
+			statusCode: resp.StatusCode,
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			body:       string(bodyBytes),
+		}
+	}
+
+	var embeddings [][]float64
+	if err := json.NewDecoder(resp.Body).Decode(&embeddings); err != nil {
+		return nil, fmt.Errorf("decoding OVH response: %w", err)
+	}
+	slog.Info("upstream batch request succeeded",
+		"request_id", requestIDFromContext(ctx),
+		"upstream_status", resp.StatusCode,
+		"latency_ms", latency.Milliseconds(),
+		"batch_size", len(batch),
+	)
+	return embeddings, nil
+}
+
+// parseRetryAfter understands both the delay-seconds and HTTP-date forms of
+// the Retry-After header, returning 0 when absent or unparseable.
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// backoffWithJitter computes an exponential backoff (200ms base) with up to
+// 50% jitter for the given attempt number (1-indexed).
+func backoffWithJitter(attempt int) time.Duration {
+	base := time.Duration(1<<uint(attempt-1)) * 200 * time.Millisecond
+	jitter := time.Duration(rand.Int63n(int64(base)/2 + 1))
+	return base + jitter
+}
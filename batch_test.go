@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitIntoBatchesBySize(t *testing.T) {
+	texts := []string{"a", "b", "c", "d", "e"}
+	tokenCounts := []int{0, 0, 0, 0, 0}
+
+	got := splitIntoBatches(texts, tokenCounts, 2, 0)
+
+	want := [][]string{{"a", "b"}, {"c", "d"}, {"e"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSplitIntoBatchesByTokenBoundary(t *testing.T) {
+	texts := []string{"a", "b", "c"}
+	tokenCounts := []int{4, 4, 4}
+
+	// maxBatchTokens of 5 means only one 4-token text fits per batch, even
+	// though maxBatchSize would allow more.
+	got := splitIntoBatches(texts, tokenCounts, 10, 5)
+
+	want := [][]string{{"a"}, {"b"}, {"c"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSplitIntoBatchesSingleOversizedText(t *testing.T) {
+	texts := []string{"short", "way-too-long"}
+	tokenCounts := []int{2, 100}
+
+	// A single text whose own token count exceeds maxBatchTokens still gets
+	// its own batch instead of being dropped or blocking forever.
+	got := splitIntoBatches(texts, tokenCounts, 10, 5)
+
+	want := [][]string{{"short"}, {"way-too-long"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSplitIntoBatchesNoTokenLimit(t *testing.T) {
+	texts := []string{"a", "b", "c"}
+	tokenCounts := []int{1000, 1000, 1000}
+
+	got := splitIntoBatches(texts, tokenCounts, 2, 0)
+
+	want := [][]string{{"a", "b"}, {"c"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSplitIntoBatchesEmpty(t *testing.T) {
+	got := splitIntoBatches(nil, nil, 2, 0)
+	if len(got) != 0 {
+		t.Errorf("expected no batches for empty input, got %v", got)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// APIError mirrors the error object OpenAI-compatible clients expect to
+// parse out of a failed response.
+type APIError struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param,omitempty"`
+	Code    *string `json:"code,omitempty"`
+}
+
+// ErrorResponse wraps an APIError in OpenAI's {"error": {...}} envelope.
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// writeAPIError writes an OpenAI-style JSON error body with the given HTTP
+// status code.
+func writeAPIError(w http.ResponseWriter, status int, apiErr APIError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: apiErr})
+}
+
+func strPtr(s string) *string { return &s }
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+type contextKey string
+
+const requestIDContextKey contextKey = "requestID"
+
+// withRequestID generates a request ID for every inbound request, echoes it
+// in the X-Request-Id response header, and stores it in the request context
+// so handlers and downstream helpers can correlate their logs.
+func withRequestID(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := uuid.NewString()
+		w.Header().Set("X-Request-Id", requestID)
+		ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// requestIDFromContext returns the request ID stored by withRequestID, or
+// "" if none is present.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
@@ -1,28 +1,46 @@
 package main
 
 import (
-	"bytes"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"log/slog"
+	"math"
 	"net/http"
 	"os"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type EmbeddingRequest struct {
-	Input interface{} `json:"input"`
-	Model string      `json:"model,omitempty"`
+	Input          interface{} `json:"input"`
+	Model          string      `json:"model,omitempty"`
+	EncodingFormat string      `json:"encoding_format,omitempty"`
 }
 
+// EmbeddingResult mirrors OpenAI's embedding object. Embedding holds either a
+// []float64 (encoding_format "float") or a base64-encoded string of
+// little-endian float32s (encoding_format "base64"), so it is left untyped
+// and serialized as-is.
 type EmbeddingResult struct {
-	Embedding []float64 `json:"embedding"`
-	Index     int       `json:"index"`
-	Object    string    `json:"object"`
+	Embedding interface{} `json:"embedding"`
+	Index     int         `json:"index"`
+	Object    string      `json:"object"`
+}
+
+// encodeEmbeddingBase64 packs a []float64 as little-endian float32s and
+// base64-encodes the resulting bytes, matching what OpenAI SDKs expect when
+// encoding_format is "base64".
+func encodeEmbeddingBase64(embedding []float64) string {
+	buf := make([]byte, 4*len(embedding))
+	for i, v := range embedding {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(float32(v)))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
 }
 
 type EmbeddingResponse struct {
@@ -42,118 +60,164 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
-func embeddingHandlerFactory(maxBatchSize int, ovhBatchApiUrl string, ovhToken string) func(http.ResponseWriter, *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
+// resolveModel picks the ModelConfig a request should be routed to: the
+// explicitly requested model, or the registry's default when req.Model is
+// empty. In legacy single-model mode, a requested model that doesn't match
+// the configured one still falls back to the default, since pre-registry
+// deployments ignored the "model" field entirely and existing OpenAI-SDK
+// clients may send their own model name.
+func resolveModel(registry *ModelRegistry, req EmbeddingRequest) (ModelConfig, bool) {
+	if req.Model == "" {
+		return registry.Default()
+	}
+	if c, ok := registry.Get(req.Model); ok {
+		return c, true
+	}
+	if registry.LegacySingleModel() {
+		return registry.Default()
+	}
+	return ModelConfig{}, false
+}
+
+// textsFromInput normalizes the OpenAI-style "input" field (a single string
+// or a list of strings) into a flat slice of texts to embed.
+func textsFromInput(input interface{}) []string {
+	switch v := input.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		texts := make([]string, len(v))
+		for i, item := range v {
+			if str, ok := item.(string); ok {
+				texts[i] = str
+			} else {
+				texts[i] = fmt.Sprintf("%v", item)
+			}
+		}
+		return texts
+	default:
+		return []string{fmt.Sprintf("%v", v)}
+	}
+}
+
+// resolveEncodingFormat validates req.EncodingFormat, defaulting to "float"
+// when absent.
+func resolveEncodingFormat(req EmbeddingRequest) (string, error) {
+	encodingFormat := req.EncodingFormat
+	if encodingFormat == "" {
+		encodingFormat = "float"
+	}
+	if encodingFormat != "float" && encodingFormat != "base64" {
+		return "", fmt.Errorf("unsupported encoding_format: %s", encodingFormat)
+	}
+	return encodingFormat, nil
+}
+
+// encodeEmbedding renders a single embedding as either a []float64 or a
+// base64-packed string, depending on encodingFormat.
+func encodeEmbedding(embedding []float64, encodingFormat string) interface{} {
+	if encodingFormat == "base64" {
+		return encodeEmbeddingBase64(embedding)
+	}
+	return embedding
+}
 
-		fmt.Printf("Received request for embeddings length: %d\n", r.ContentLength)
+func embeddingHandlerFactory(registry *ModelRegistry, rateLimiter *keyRateLimiter, embeddingConcurrency int, embeddingMaxAttempts int) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := requestIDFromContext(r.Context())
+		slog.Info("received embeddings request", "request_id", requestID, "content_length", r.ContentLength)
 
 		// Only handle POST requests
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			fmt.Printf("Method not allowed: %s\n", r.Method)
+			writeAPIError(w, http.StatusMethodNotAllowed, APIError{Message: "Method not allowed", Type: "invalid_request_error"})
 			return
 		}
 
 		var req EmbeddingRequest
-		err := json.NewDecoder(r.Body).Decode(&req)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Error parsing request: %v", err), http.StatusBadRequest)
-			fmt.Printf("Error parsing request: %v\n", err)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, APIError{Message: fmt.Sprintf("Error parsing request: %v", err), Type: "invalid_request_error"})
 			return
 		}
 
-		// Convert input to slice of texts
-		var texts []string
-		switch v := req.Input.(type) {
-		case string:
-			texts = []string{v}
-		case []interface{}:
-			texts = make([]string, len(v))
-			for i, item := range v {
-				if str, ok := item.(string); ok {
-					texts[i] = str
-				} else {
-					texts[i] = fmt.Sprintf("%v", item)
-				}
-			}
-		default:
-			texts = []string{fmt.Sprintf("%v", v)}
+		modelConfig, ok := resolveModel(registry, req)
+		if !ok {
+			writeAPIError(w, http.StatusBadRequest, APIError{
+				Message: fmt.Sprintf("Unknown model: %s", req.Model),
+				Type:    "invalid_request_error",
+				Param:   strPtr("model"),
+			})
+			return
 		}
 
-		// Process in batches
-		var allEmbeddings [][]float64
-		for i := 0; i < len(texts); i += maxBatchSize {
-			end := i + maxBatchSize
-			if end > len(texts) {
-				end = len(texts)
-			}
-			batch := texts[i:end]
-
-			fmt.Printf("Processing batch %d/%d, size: %d\n", i/maxBatchSize+1, (len(texts)+maxBatchSize-1)/maxBatchSize, len(batch))
-
-			// Send batch request to OVH
-			batchJSON, err := json.Marshal(batch)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("Error marshaling batch: %v", err), http.StatusInternalServerError)
-				fmt.Printf("Error marshaling batch: %v\n", err)
-				return
-			}
+		encodingFormat, err := resolveEncodingFormat(req)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, APIError{Message: err.Error(), Type: "invalid_request_error", Param: strPtr("encoding_format")})
+			return
+		}
 
-			req, err := http.NewRequest("POST", ovhBatchApiUrl, bytes.NewBuffer(batchJSON))
-			if err != nil {
-				http.Error(w, fmt.Sprintf("Error creating request: %v", err), http.StatusInternalServerError)
-				fmt.Printf("Error creating request: %v\n", err)
-				return
-			}
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ovhToken))
-
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("Error calling OVH API: %v", err), http.StatusInternalServerError)
-				fmt.Printf("Error calling OVH API: %v\n", err)
-				return
-			}
-			defer resp.Body.Close()
+		texts := textsFromInput(req.Input)
+		tokenCounts := countTokens(modelConfig.Tokenizer, texts)
+		if err := enforceMaxRequestTokens(tokenCounts, modelConfig.MaxRequestTokens); err != nil {
+			writeAPIError(w, http.StatusBadRequest, APIError{
+				Message: err.Error(),
+				Type:    "invalid_request_error",
+				Code:    strPtr("context_length_exceeded"),
+			})
+			return
+		}
+		totalTokens := 0
+		for _, c := range tokenCounts {
+			totalTokens += c
+		}
 
-			if resp.StatusCode != http.StatusOK {
-				bodyBytes, _ := ioutil.ReadAll(resp.Body)
-				http.Error(w, fmt.Sprintf("Error from OVH API (batch starting at index %d): %d, response: %s", i, resp.StatusCode, string(bodyBytes)), http.StatusInternalServerError)
-				fmt.Printf("Error from OVH API (batch starting at index %d): %d, response: %s\n", i, resp.StatusCode, string(bodyBytes))
-				return
-			}
+		if err := rateLimiter.checkTokenBudget(totalTokens); err != nil {
+			writeAPIError(w, http.StatusBadRequest, APIError{
+				Message: err.Error(),
+				Type:    "invalid_request_error",
+				Code:    strPtr("context_length_exceeded"),
+			})
+			return
+		}
 
-			var batchEmbeddings [][]float64
-			err = json.NewDecoder(resp.Body).Decode(&batchEmbeddings)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("Error decoding OVH response: %v", err), http.StatusInternalServerError)
-				fmt.Printf("Error decoding OVH response: %v\n", err)
-				return
-			}
+		allowed, remainingTokens := rateLimiter.allowTokens(apiKeyFromContext(r.Context()), totalTokens)
+		if remainingTokens >= 0 {
+			w.Header().Set("X-RateLimit-Remaining-Tokens", strconv.Itoa(remainingTokens))
+		}
+		if !allowed {
+			writeAPIError(w, http.StatusTooManyRequests, APIError{
+				Message: "Rate limit reached for tokens. Please slow down.",
+				Type:    "tokens",
+				Code:    strPtr("rate_limit_exceeded"),
+			})
+			return
+		}
 
-			allEmbeddings = append(allEmbeddings, batchEmbeddings...)
+		batches := splitIntoBatches(texts, tokenCounts, modelConfig.BatchSize, modelConfig.MaxBatchTokens)
+		allEmbeddings, err := dispatchBatches(r.Context(), batches, batchDispatchConfig{
+			backendURL:  modelConfig.BackendURL,
+			token:       modelConfig.Token,
+			concurrency: embeddingConcurrency,
+			maxAttempts: embeddingMaxAttempts,
+		})
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, APIError{Message: fmt.Sprintf("Error dispatching batches: %v", err), Type: "api_error"})
+			return
 		}
 
 		// Format response to match OpenAI's format
 		embeddingsResults := make([]EmbeddingResult, len(allEmbeddings))
 		for i, embedding := range allEmbeddings {
 			embeddingsResults[i] = EmbeddingResult{
-				Embedding: embedding,
+				Embedding: encodeEmbedding(embedding, encodingFormat),
 				Index:     i,
 				Object:    "embedding",
 			}
 		}
 
-		// Count tokens (simple approximation by word count)
-		totalTokens := 0
-		for _, text := range texts {
-			totalTokens += len(strings.Fields(text))
-		}
-
 		response := EmbeddingResponse{
 			Data:   embeddingsResults,
-			Model:  "ovh-embeddings",
+			Model:  modelConfig.ID,
 			Object: "list",
 		}
 		response.Usage.PromptTokens = totalTokens
@@ -161,7 +225,13 @@ func embeddingHandlerFactory(maxBatchSize int, ovhBatchApiUrl string, ovhToken s
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(response)
-		fmt.Println("Successfully processed request")
+		slog.Info("completed embeddings request",
+			"request_id", requestID,
+			"model", modelConfig.ID,
+			"batch_count", len(batches),
+			"total_tokens", totalTokens,
+			"latency_ms", time.Since(start).Milliseconds(),
+		)
 	}
 }
 func main() {
@@ -173,23 +243,45 @@ func main() {
 		log.Println("Successfully loaded .env file")
 	}
 
-	maxBatchSize, err := strconv.Atoi(getEnv("BATCH_SIZE", "10"))
+	registry, err := loadModelRegistry()
 	if err != nil {
-		panic("Error parsing OVH_BATCH_MAX_BATCH_SIZE:" + err.Error())
+		panic("Error loading model registry: " + err.Error())
 	}
-	ovhBatchApiUrl := os.Getenv("OVH_BATCH_API_URL")
 
-	if ovhBatchApiUrl == "" {
-		panic("OVH_BATCH_API_URL not set")
+	embeddingConcurrency, err := strconv.Atoi(getEnv("EMBEDDING_CONCURRENCY", "4"))
+	if err != nil {
+		panic("Error parsing EMBEDDING_CONCURRENCY: " + err.Error())
+	}
+	if embeddingConcurrency < 1 {
+		panic(fmt.Sprintf("EMBEDDING_CONCURRENCY must be at least 1, got %d", embeddingConcurrency))
+	}
+	embeddingMaxAttempts, err := strconv.Atoi(getEnv("EMBEDDING_MAX_ATTEMPTS", "3"))
+	if err != nil {
+		panic("Error parsing EMBEDDING_MAX_ATTEMPTS: " + err.Error())
+	}
+
+	apiKeys, err := loadAPIKeys()
+	if err != nil {
+		panic("Error loading API keys: " + err.Error())
+	}
+	requestsPerMinute, err := strconv.Atoi(getEnv("RATE_LIMIT_REQUESTS_PER_MINUTE", "0"))
+	if err != nil {
+		panic("Error parsing RATE_LIMIT_REQUESTS_PER_MINUTE: " + err.Error())
+	}
+	tokensPerMinute, err := strconv.Atoi(getEnv("RATE_LIMIT_TOKENS_PER_MINUTE", "0"))
+	if err != nil {
+		panic("Error parsing RATE_LIMIT_TOKENS_PER_MINUTE: " + err.Error())
 	}
+	rateLimiter := newKeyRateLimiter(requestsPerMinute, tokensPerMinute)
 
-	// Get OVH token from environment variable
-	ovhToken := os.Getenv("OVH_AI_ENDPOINTS_ACCESS_TOKEN")
-	if ovhToken == "" {
-		panic("OVH token not set\n")
+	withMiddleware := func(handler http.HandlerFunc) http.HandlerFunc {
+		return withRequestID(withAuth(apiKeys, rateLimiter, handler))
 	}
 
-	http.HandleFunc("/v1/embeddings", embeddingHandlerFactory(maxBatchSize, ovhBatchApiUrl, ovhToken))
+	http.HandleFunc("/v1/embeddings", withMiddleware(embeddingHandlerFactory(registry, rateLimiter, embeddingConcurrency, embeddingMaxAttempts)))
+	http.HandleFunc("/v1/embeddings/stream", withMiddleware(streamEmbeddingHandlerFactory(registry, rateLimiter, embeddingConcurrency, embeddingMaxAttempts)))
+	http.HandleFunc("/v1/models", withMiddleware(modelsHandlerFactory(registry)))
+	http.HandleFunc("/v1/models/", withMiddleware(modelHandlerFactory(registry)))
 	port := getEnv("PORT", "14152")
 	fmt.Println("Server starting on port ", port)
 	log.Fatal(http.ListenAndServe(":"+port, nil))
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestEncodeEmbeddingBase64(t *testing.T) {
+	embedding := []float64{0, 1, -1.5, 3.14159}
+
+	got := encodeEmbeddingBase64(embedding)
+
+	raw, err := base64.StdEncoding.DecodeString(got)
+	if err != nil {
+		t.Fatalf("decoding base64 output: %v", err)
+	}
+	if len(raw) != 4*len(embedding) {
+		t.Fatalf("expected %d bytes, got %d", 4*len(embedding), len(raw))
+	}
+	for i, want := range embedding {
+		bits := binary.LittleEndian.Uint32(raw[i*4:])
+		gotFloat := float64(math.Float32frombits(bits))
+		if gotFloat != float64(float32(want)) {
+			t.Errorf("index %d: got %v, want %v", i, gotFloat, float32(want))
+		}
+	}
+}
+
+func TestEncodeEmbeddingBase64Empty(t *testing.T) {
+	if got := encodeEmbeddingBase64(nil); got != "" {
+		t.Errorf("expected empty string for nil embedding, got %q", got)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ModelObject mirrors OpenAI's model object returned by GET /v1/models and
+// GET /v1/models/{id}.
+type ModelObject struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// ModelListResponse mirrors OpenAI's {object:"list", data:[...]} envelope.
+type ModelListResponse struct {
+	Object string        `json:"object"`
+	Data   []ModelObject `json:"data"`
+}
+
+func toModelObject(c ModelConfig) ModelObject {
+	return ModelObject{
+		ID:      c.ID,
+		Object:  "model",
+		Created: c.CreatedAt,
+		OwnedBy: "ovh",
+	}
+}
+
+// modelsHandlerFactory serves GET /v1/models, listing every model declared
+// in the registry.
+func modelsHandlerFactory(registry *ModelRegistry) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, APIError{Message: "Method not allowed", Type: "invalid_request_error"})
+			return
+		}
+
+		configs := registry.List()
+		data := make([]ModelObject, len(configs))
+		for i, c := range configs {
+			data[i] = toModelObject(c)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ModelListResponse{Object: "list", Data: data})
+	}
+}
+
+// modelHandlerFactory serves GET /v1/models/{id}, returning a single model
+// object or a 404 when the ID is not registered.
+func modelHandlerFactory(registry *ModelRegistry) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, APIError{Message: "Method not allowed", Type: "invalid_request_error"})
+			return
+		}
+
+		id := strings.TrimPrefix(r.URL.Path, "/v1/models/")
+		config, ok := registry.Get(id)
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, APIError{
+				Message: fmt.Sprintf("Model not found: %s", id),
+				Type:    "invalid_request_error",
+				Code:    strPtr("model_not_found"),
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toModelObject(config))
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ModelConfig describes one logical OpenAI-style model ID exposed by this
+// adapter and the OVH backend deployment it is routed to.
+type ModelConfig struct {
+	ID                string    `json:"id"`
+	BackendURL        string    `json:"backend_url"`
+	BatchSize         int       `json:"batch_size"`
+	Token             string    `json:"token,omitempty"`
+	TokenizerEncoding string    `json:"tokenizer_encoding,omitempty"`
+	MaxRequestTokens  int       `json:"max_request_tokens,omitempty"`
+	MaxBatchTokens    int       `json:"max_batch_tokens,omitempty"`
+	CreatedAt         int64     `json:"-"`
+	Tokenizer         Tokenizer `json:"-"`
+}
+
+// ModelRegistry maps logical model IDs to their OVH backend configuration.
+// Order is preserved so /v1/models returns configs in the order they were
+// declared.
+type ModelRegistry struct {
+	models map[string]ModelConfig
+	order  []string
+
+	// legacySingleModel is true when this registry was assembled from the
+	// legacy single-backend env vars rather than MODELS_CONFIG_FILE. It lets
+	// resolveModel preserve pre-registry behavior of routing every request
+	// to the one configured model regardless of the "model" field sent.
+	legacySingleModel bool
+}
+
+// loadModelRegistry builds a ModelRegistry from the JSON file at
+// MODELS_CONFIG_FILE when set. Otherwise it falls back to a single model
+// assembled from the legacy OVH_BATCH_API_URL / OVH_AI_ENDPOINTS_ACCESS_TOKEN
+// / BATCH_SIZE env vars, so existing single-backend deployments keep working
+// unchanged.
+func loadModelRegistry() (*ModelRegistry, error) {
+	reg := &ModelRegistry{models: make(map[string]ModelConfig)}
+	loadedAt := time.Now().Unix()
+
+	if configPath := os.Getenv("MODELS_CONFIG_FILE"); configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading models config file: %w", err)
+		}
+		var configs []ModelConfig
+		if err := json.Unmarshal(data, &configs); err != nil {
+			return nil, fmt.Errorf("parsing models config file: %w", err)
+		}
+		if len(configs) == 0 {
+			return nil, fmt.Errorf("models config file %s defines no models", configPath)
+		}
+		for _, c := range configs {
+			c.CreatedAt = loadedAt
+			if c.BatchSize < 1 {
+				return nil, fmt.Errorf("model %q: batch_size must be at least 1, got %d", c.ID, c.BatchSize)
+			}
+			if err := resolveTokenizer(&c); err != nil {
+				return nil, err
+			}
+			reg.add(c)
+		}
+		return reg, nil
+	}
+
+	ovhBatchApiUrl := os.Getenv("OVH_BATCH_API_URL")
+	if ovhBatchApiUrl == "" {
+		return nil, fmt.Errorf("no MODELS_CONFIG_FILE set and OVH_BATCH_API_URL not set")
+	}
+	batchSize, err := strconv.Atoi(getEnv("BATCH_SIZE", "10"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing BATCH_SIZE: %w", err)
+	}
+	if batchSize < 1 {
+		return nil, fmt.Errorf("BATCH_SIZE must be at least 1, got %d", batchSize)
+	}
+	c := ModelConfig{
+		ID:                getEnv("MODEL_ID", "ovh-embeddings"),
+		BackendURL:        ovhBatchApiUrl,
+		BatchSize:         batchSize,
+		Token:             os.Getenv("OVH_AI_ENDPOINTS_ACCESS_TOKEN"),
+		TokenizerEncoding: os.Getenv("DEFAULT_TOKENIZER_ENCODING"),
+		CreatedAt:         loadedAt,
+	}
+	if err := resolveTokenizer(&c); err != nil {
+		return nil, err
+	}
+	reg.legacySingleModel = true
+	reg.add(c)
+	return reg, nil
+}
+
+// resolveTokenizer fills in c.Tokenizer from c.TokenizerEncoding, defaulting
+// to DEFAULT_TOKENIZER_ENCODING when a model config loaded from
+// MODELS_CONFIG_FILE doesn't specify one. Neither falls back to a tiktoken
+// encoding on their own: tokenizer_encoding (and DEFAULT_TOKENIZER_ENCODING)
+// are opt-in, since resolving one may need to fetch the encoding over the
+// network. Leaving both unset keeps token counting fully offline via
+// wordCountTokenizer.
+func resolveTokenizer(c *ModelConfig) error {
+	encodingName := c.TokenizerEncoding
+	if encodingName == "" {
+		encodingName = os.Getenv("DEFAULT_TOKENIZER_ENCODING")
+	}
+	tokenizer, err := newTokenizer(encodingName)
+	if err != nil {
+		return fmt.Errorf("model %q: %w", c.ID, err)
+	}
+	c.Tokenizer = tokenizer
+	return nil
+}
+
+func (r *ModelRegistry) add(c ModelConfig) {
+	if _, exists := r.models[c.ID]; !exists {
+		r.order = append(r.order, c.ID)
+	}
+	r.models[c.ID] = c
+}
+
+// Get looks up a model config by its logical ID.
+func (r *ModelRegistry) Get(id string) (ModelConfig, bool) {
+	c, ok := r.models[id]
+	return c, ok
+}
+
+// Default returns the first declared model config, used when a request
+// omits the "model" field.
+func (r *ModelRegistry) Default() (ModelConfig, bool) {
+	if len(r.order) == 0 {
+		return ModelConfig{}, false
+	}
+	return r.models[r.order[0]], true
+}
+
+// LegacySingleModel reports whether this registry was assembled from the
+// legacy single-backend env vars rather than MODELS_CONFIG_FILE.
+func (r *ModelRegistry) LegacySingleModel() bool {
+	return r.legacySingleModel
+}
+
+// List returns all model configs in declaration order.
+func (r *ModelRegistry) List() []ModelConfig {
+	out := make([]ModelConfig, 0, len(r.order))
+	for _, id := range r.order {
+		out = append(out, r.models[id])
+	}
+	return out
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// streamUsageLine is the terminal NDJSON line emitted once every batch has
+// completed, mirroring the usage block of EmbeddingResponse.
+type streamUsageLine struct {
+	Object       string `json:"object"`
+	PromptTokens int    `json:"prompt_tokens"`
+	TotalTokens  int    `json:"total_tokens"`
+}
+
+// streamErrorLine is emitted in place of the usage line when a batch fails
+// partway through the stream.
+type streamErrorLine struct {
+	Object  string `json:"object"`
+	Message string `json:"message"`
+}
+
+// streamEmbeddingHandlerFactory serves POST /v1/embeddings/stream: the same
+// request body as /v1/embeddings, but responding with application/x-ndjson,
+// one EmbeddingResult per line as each OVH batch completes, flushed
+// immediately so callers can start consuming vectors before the whole
+// request finishes.
+func streamEmbeddingHandlerFactory(registry *ModelRegistry, rateLimiter *keyRateLimiter, embeddingConcurrency int, embeddingMaxAttempts int) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := requestIDFromContext(r.Context())
+		slog.Info("received streaming embeddings request", "request_id", requestID, "content_length", r.ContentLength)
+
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, APIError{Message: "Method not allowed", Type: "invalid_request_error"})
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeAPIError(w, http.StatusInternalServerError, APIError{Message: "Streaming unsupported", Type: "api_error"})
+			return
+		}
+
+		var req EmbeddingRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, APIError{Message: fmt.Sprintf("Error parsing request: %v", err), Type: "invalid_request_error"})
+			return
+		}
+
+		modelConfig, ok := resolveModel(registry, req)
+		if !ok {
+			writeAPIError(w, http.StatusBadRequest, APIError{
+				Message: fmt.Sprintf("Unknown model: %s", req.Model),
+				Type:    "invalid_request_error",
+				Param:   strPtr("model"),
+			})
+			return
+		}
+
+		encodingFormat, err := resolveEncodingFormat(req)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, APIError{Message: err.Error(), Type: "invalid_request_error", Param: strPtr("encoding_format")})
+			return
+		}
+
+		texts := textsFromInput(req.Input)
+		tokenCounts := countTokens(modelConfig.Tokenizer, texts)
+		if err := enforceMaxRequestTokens(tokenCounts, modelConfig.MaxRequestTokens); err != nil {
+			writeAPIError(w, http.StatusBadRequest, APIError{
+				Message: err.Error(),
+				Type:    "invalid_request_error",
+				Code:    strPtr("context_length_exceeded"),
+			})
+			return
+		}
+		totalTokens := 0
+		for _, c := range tokenCounts {
+			totalTokens += c
+		}
+
+		if err := rateLimiter.checkTokenBudget(totalTokens); err != nil {
+			writeAPIError(w, http.StatusBadRequest, APIError{
+				Message: err.Error(),
+				Type:    "invalid_request_error",
+				Code:    strPtr("context_length_exceeded"),
+			})
+			return
+		}
+
+		allowed, remainingTokens := rateLimiter.allowTokens(apiKeyFromContext(r.Context()), totalTokens)
+		if remainingTokens >= 0 {
+			w.Header().Set("X-RateLimit-Remaining-Tokens", strconv.Itoa(remainingTokens))
+		}
+		if !allowed {
+			writeAPIError(w, http.StatusTooManyRequests, APIError{
+				Message: "Rate limit reached for tokens. Please slow down.",
+				Type:    "tokens",
+				Code:    strPtr("rate_limit_exceeded"),
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+
+		batches := splitIntoBatches(texts, tokenCounts, modelConfig.BatchSize, modelConfig.MaxBatchTokens)
+		var mu sync.Mutex
+		var streamErr error
+		dispatchBatchesStreaming(r.Context(), batches, batchDispatchConfig{
+			backendURL:  modelConfig.BackendURL,
+			token:       modelConfig.Token,
+			concurrency: embeddingConcurrency,
+			maxAttempts: embeddingMaxAttempts,
+		}, func(batchStartIndex int, embeddings [][]float64, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if streamErr == nil {
+					streamErr = err
+				}
+				return
+			}
+			if streamErr != nil {
+				return
+			}
+			for j, embedding := range embeddings {
+				encoder.Encode(EmbeddingResult{
+					Embedding: encodeEmbedding(embedding, encodingFormat),
+					Index:     batchStartIndex + j,
+					Object:    "embedding",
+				})
+			}
+			flusher.Flush()
+		})
+
+		if streamErr != nil {
+			encoder.Encode(streamErrorLine{Object: "error", Message: streamErr.Error()})
+			flusher.Flush()
+			slog.Error("streaming embeddings request failed", "request_id", requestID, "error", streamErr, "latency_ms", time.Since(start).Milliseconds())
+			return
+		}
+
+		encoder.Encode(streamUsageLine{Object: "usage", PromptTokens: totalTokens, TotalTokens: totalTokens})
+		flusher.Flush()
+		slog.Info("completed streaming embeddings request",
+			"request_id", requestID,
+			"model", modelConfig.ID,
+			"batch_count", len(batches),
+			"total_tokens", totalTokens,
+			"latency_ms", time.Since(start).Milliseconds(),
+		)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// Tokenizer counts how many tokens a piece of text costs against a model's
+// context budget.
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// wordCountTokenizer approximates token count by whitespace-separated word
+// count. It is the fallback when no tiktoken encoding is configured for a
+// model.
+type wordCountTokenizer struct{}
+
+func (wordCountTokenizer) CountTokens(text string) int {
+	return len(strings.Fields(text))
+}
+
+// tiktokenTokenizer counts tokens using a tiktoken-go BPE encoding, matching
+// what OpenAI's own SDKs report for usage accounting.
+type tiktokenTokenizer struct {
+	encoding *tiktoken.Tiktoken
+}
+
+func (t *tiktokenTokenizer) CountTokens(text string) int {
+	return len(t.encoding.Encode(text, nil, nil))
+}
+
+// newTokenizer resolves a Tokenizer for the given tiktoken encoding name
+// (e.g. "cl100k_base"). An empty name falls back to word-count estimation.
+//
+// tiktoken-go fetches unseen encodings over the network (and can block
+// startup or fail outright if that fetch is unavailable), so a failure to
+// load the requested encoding is not fatal: we log it and fall back to
+// wordCountTokenizer rather than taking down the whole server.
+func newTokenizer(encodingName string) (Tokenizer, error) {
+	if encodingName == "" {
+		return wordCountTokenizer{}, nil
+	}
+	encoding, err := tiktoken.GetEncoding(encodingName)
+	if err != nil {
+		slog.Warn("falling back to word-count tokenizer: loading tiktoken encoding failed",
+			"encoding", encodingName, "error", err)
+		return wordCountTokenizer{}, nil
+	}
+	return &tiktokenTokenizer{encoding: encoding}, nil
+}
+
+// countTokens returns the per-text token count for texts using tokenizer.
+func countTokens(tokenizer Tokenizer, texts []string) []int {
+	counts := make([]int, len(texts))
+	for i, text := range texts {
+		counts[i] = tokenizer.CountTokens(text)
+	}
+	return counts
+}
+
+// enforceMaxRequestTokens rejects the request with an OpenAI-style
+// context_length_exceeded error once the total token count across all texts
+// exceeds maxRequestTokens. A non-positive maxRequestTokens means unlimited.
+func enforceMaxRequestTokens(tokenCounts []int, maxRequestTokens int) error {
+	if maxRequestTokens <= 0 {
+		return nil
+	}
+	total := 0
+	for _, c := range tokenCounts {
+		total += c
+	}
+	if total > maxRequestTokens {
+		return fmt.Errorf("this request contains %d tokens, exceeding the model's max of %d tokens", total, maxRequestTokens)
+	}
+	return nil
+}